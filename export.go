@@ -0,0 +1,209 @@
+package iavl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// exportBufferSize is the maximum number of nodes (leaves and inner nodes)
+// batched into a single chunk returned by SnapshotExporter.Next. It mirrors the chunk size used by the SDK's
+// snapshot manager so that a state-sync provider can stream chunks directly
+// off the wire without re-buffering them.
+const exportBufferSize = 10000
+
+// ErrorExportDone is returned by SnapshotExporter.Next once every leaf in the
+// exported version has been emitted.
+var ErrorExportDone = errors.New("export is complete")
+
+// SnapshotItem is a single node captured while walking a flushed version of
+// the tree for state-sync export. Items are emitted in post-order (a node's
+// children before the node itself), with Value populated only for leaves
+// (Height 0). Transmitting inner nodes - not just leaves - along with their
+// original Height and Version is what lets SnapshotImporter reassemble the exact
+// historical tree shape, rather than a shape merely consistent with the
+// same keys, so the rebuilt root hash matches the original.
+type SnapshotItem struct {
+	Key     []byte
+	Value   []byte
+	Version int64
+	Height  int8
+}
+
+// Marshal encodes the item as a sequence of length-prefixed fields, using
+// the same varint/byte encoding the tree already uses to hash and persist
+// nodes (see encoding.go). This keeps chunk encoding dependency-free and
+// consistent with the rest of the on-disk format.
+func (item *SnapshotItem) Marshal() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := encodeBytes(buf, item.Key); err != nil {
+		return nil, fmt.Errorf("marshal snapshot item key: %w", err)
+	}
+	if err := encodeBytes(buf, item.Value); err != nil {
+		return nil, fmt.Errorf("marshal snapshot item value: %w", err)
+	}
+	if err := encodeVarint(buf, item.Version); err != nil {
+		return nil, fmt.Errorf("marshal snapshot item version: %w", err)
+	}
+	if err := encodeVarint(buf, int64(item.Height)); err != nil {
+		return nil, fmt.Errorf("marshal snapshot item height: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes an item previously produced by Marshal.
+func (item *SnapshotItem) Unmarshal(bz []byte) error {
+	buf := bytes.NewReader(bz)
+
+	key, err := decodeBytes(buf)
+	if err != nil {
+		return fmt.Errorf("unmarshal snapshot item key: %w", err)
+	}
+	value, err := decodeBytes(buf)
+	if err != nil {
+		return fmt.Errorf("unmarshal snapshot item value: %w", err)
+	}
+	version, err := decodeVarint(buf)
+	if err != nil {
+		return fmt.Errorf("unmarshal snapshot item version: %w", err)
+	}
+	height, err := decodeVarint(buf)
+	if err != nil {
+		return fmt.Errorf("unmarshal snapshot item height: %w", err)
+	}
+
+	item.Key = key
+	item.Value = value
+	item.Version = version
+	item.Height = int8(height)
+	return nil
+}
+
+// exportNode tracks how far a depth-first traversal has descended into a
+// node's children, so the SnapshotExporter can resume a partially-visited subtree
+// across chunk boundaries.
+type exportNode struct {
+	node  *Node
+	left  bool
+	right bool
+}
+
+// SnapshotExporter walks every node of an already-flushed version of a tree, in
+// post-order, emitting them as SnapshotItem chunks. Because it reads through
+// the node cache/disk rather than holding the whole tree in memory, and only
+// keeps one exportNode per level of in-progress descent on its stack, export
+// memory cost is proportional to tree depth, not tree size.
+type SnapshotExporter struct {
+	tree    *ImmutableTree
+	stack   []*exportNode
+	ndb     *nodeDB
+	version int64
+	closed  bool
+}
+
+// ExportSnapshot returns a SnapshotExporter over the given version. The
+// version must already be a flushed snapshot (see PruningOptions/
+// FlushVersion) since the exporter reads nodes back from ndb rather than
+// from the live working set.
+//
+// ExportSnapshot pins the version for the SnapshotExporter's lifetime (see
+// versionset.go), the same way VersionSet.View does: without it, a
+// SetPruningStrategy call that tightens retention partway through a chunked
+// export could delete the very nodes Next is still walking. Close releases
+// the pin.
+func (tree *MutableTree) ExportSnapshot(version int64) (*SnapshotExporter, error) {
+	ok, err := tree.ndb.HasVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("export version %d: %w", version, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("export version %d: %w", version, ErrVersionDoesNotExist)
+	}
+
+	tree.ndb.pinVersion(version)
+
+	itree, err := tree.GetImmutable(version)
+	if err != nil {
+		tree.ndb.unpinVersion(version)
+		return nil, fmt.Errorf("export version %d: %w", version, err)
+	}
+
+	e := &SnapshotExporter{tree: itree, ndb: tree.ndb, version: version}
+	if itree.root != nil {
+		e.stack = []*exportNode{{node: itree.root}}
+	}
+	return e, nil
+}
+
+// Next returns up to exportBufferSize nodes in post-order (a node's children
+// are always emitted before the node itself). It returns ErrorExportDone,
+// with a nil slice, once the tree has been fully walked.
+func (e *SnapshotExporter) Next() ([]SnapshotItem, error) {
+	if e.stack == nil {
+		return nil, ErrorExportDone
+	}
+
+	items := make([]SnapshotItem, 0, exportBufferSize)
+	for len(e.stack) > 0 && len(items) < exportBufferSize {
+		top := e.stack[len(e.stack)-1]
+
+		if top.node.isLeaf() {
+			e.stack = e.stack[:len(e.stack)-1]
+			items = append(items, SnapshotItem{
+				Key:     top.node.key,
+				Value:   top.node.value,
+				Version: top.node.version,
+				Height:  top.node.height,
+			})
+			continue
+		}
+
+		if !top.left {
+			top.left = true
+			left, err := top.node.getLeftNode(e.tree)
+			if err != nil {
+				return nil, fmt.Errorf("export: %w", err)
+			}
+			e.stack = append(e.stack, &exportNode{node: left})
+			continue
+		}
+
+		if !top.right {
+			top.right = true
+			right, err := top.node.getRightNode(e.tree)
+			if err != nil {
+				return nil, fmt.Errorf("export: %w", err)
+			}
+			e.stack = append(e.stack, &exportNode{node: right})
+			continue
+		}
+
+		// Both children have been emitted: this inner node can now be
+		// emitted itself, completing its post-order triple.
+		e.stack = e.stack[:len(e.stack)-1]
+		items = append(items, SnapshotItem{
+			Key:     top.node.key,
+			Version: top.node.version,
+			Height:  top.node.height,
+		})
+	}
+
+	if len(e.stack) == 0 {
+		e.stack = nil
+	}
+	if len(items) == 0 {
+		return nil, ErrorExportDone
+	}
+	return items, nil
+}
+
+// Close releases the exporter, including the pin it holds on its version.
+// It is safe to call more than once.
+func (e *SnapshotExporter) Close() error {
+	e.stack = nil
+	if !e.closed {
+		e.closed = true
+		e.ndb.unpinVersion(e.version)
+	}
+	return nil
+}
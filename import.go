@@ -0,0 +1,266 @@
+package iavl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	db "github.com/tendermint/tm-db"
+)
+
+// ErrImportOutOfOrder is returned when SnapshotImporter.Add receives a leaf whose
+// key is not strictly greater than the last leaf key it accepted.
+var ErrImportOutOfOrder = errors.New("import: leaf keys must be added in ascending order")
+
+// importWalPrefix namespaces the write-ahead log entries an in-progress
+// SnapshotImporter persists to the target tree's own database, so that a crashed or
+// restarted process can resume a state-sync import without re-requesting
+// chunks it already applied.
+const importWalPrefix = "iavl.import.wal/"
+
+// SnapshotImporter rebuilds a tree version from a stream of SnapshotItem nodes
+// produced by SnapshotExporter, which walks the source tree in post-order (every
+// node, inner ones included, after both of its children). Reassembling the
+// exact historical node stream - rather than re-inserting leaves through the
+// ordinary Set path - is what makes the rebuilt root hash match the
+// original: IAVL's node hash depends on each node's own height and version,
+// and tree shape depends on insertion order, neither of which a fresh
+// sequence of Sets can be relied on to reproduce.
+type SnapshotImporter struct {
+	tree         *MutableTree
+	version      int64
+	expectedHash []byte
+
+	ndb   *nodeDB
+	batch db.Batch
+
+	stack   []*Node
+	lastKey []byte
+
+	wal    db.DB
+	walSeq int64
+}
+
+// ImportSnapshot returns a SnapshotImporter that will rebuild the given
+// version, verifying the final root hash against expectedHash before
+// committing. If a previous SnapshotImporter for the same version and
+// expected hash left a WAL behind (e.g. the process crashed mid-import),
+// its entries are replayed so callers can simply resume sending chunks from
+// where they left off.
+//
+// ImportSnapshot refuses to rebuild a version that already exists: this
+// feature is meant to rebuild a tree version on a fresh nodeDB (e.g. a new
+// node catching up via state-sync), and writing over an existing version's
+// root pointer would silently corrupt history rather than error.
+func (tree *MutableTree) ImportSnapshot(version int64, expectedHash []byte) (*SnapshotImporter, error) {
+	if version <= 0 {
+		return nil, fmt.Errorf("import: version must be positive, got %d", version)
+	}
+	if ok, err := tree.ndb.HasVersion(version); err != nil {
+		return nil, fmt.Errorf("import: checking existing version %d: %w", version, err)
+	} else if ok {
+		return nil, fmt.Errorf("import: version %d already exists; import must target a fresh nodeDB", version)
+	}
+
+	i := &SnapshotImporter{
+		tree:         tree,
+		version:      version,
+		expectedHash: expectedHash,
+		ndb:          tree.ndb,
+		batch:        tree.ndb.db.NewBatch(),
+		wal:          tree.ndb.db,
+	}
+
+	if err := i.resume(); err != nil {
+		return nil, fmt.Errorf("import: resuming WAL: %w", err)
+	}
+	return i, nil
+}
+
+// walKey returns the persisted key for the seq'th applied chunk item of this
+// import, namespaced by target version and expected hash so that a stale WAL
+// from an abandoned import (different expected hash) is never replayed.
+func (i *SnapshotImporter) walKey(seq int64) []byte {
+	return []byte(fmt.Sprintf("%s%d/%x/%020d", importWalPrefix, i.version, i.expectedHash, seq))
+}
+
+func (i *SnapshotImporter) walPrefix() []byte {
+	return []byte(fmt.Sprintf("%s%d/%x/", importWalPrefix, i.version, i.expectedHash))
+}
+
+// prefixRangeEnd returns the exclusive upper bound of the key range covering
+// every key with the given prefix. tm-db's DB.Iterator takes an explicit
+// [start, end) range rather than a prefix, and the tm-db package itself
+// ships no prefix-iteration helper, so callers that want "every key under
+// this prefix" compute the end bound this way.
+func prefixRangeEnd(prefix []byte) []byte {
+	if len(prefix) == 0 {
+		return nil
+	}
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	// prefix was all 0xff bytes: there is no finite upper bound.
+	return nil
+}
+
+// resume replays any WAL entries left behind by a previous, incomplete
+// SnapshotImporter for the same (version, expectedHash) pair.
+func (i *SnapshotImporter) resume() error {
+	prefix := i.walPrefix()
+	it, err := i.wal.Iterator(prefix, prefixRangeEnd(prefix))
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	for ; it.Valid(); it.Next() {
+		var item SnapshotItem
+		if err := item.Unmarshal(it.Value()); err != nil {
+			return fmt.Errorf("decoding WAL entry: %w", err)
+		}
+		if err := i.apply(item); err != nil {
+			return err
+		}
+		i.walSeq++
+	}
+	return it.Error()
+}
+
+// Add applies the next node of the post-order stream produced by SnapshotExporter.
+// Leaves (height 0) must arrive in strictly ascending key order; an inner
+// node must arrive only once both of its children are already on the stack,
+// exactly as SnapshotExporter emits them.
+func (i *SnapshotImporter) Add(item SnapshotItem) error {
+	if err := i.apply(item); err != nil {
+		return err
+	}
+
+	bz, err := item.Marshal()
+	if err != nil {
+		return fmt.Errorf("import: encoding WAL entry: %w", err)
+	}
+	if err := i.wal.Set(i.walKey(i.walSeq), bz); err != nil {
+		return fmt.Errorf("import: persisting WAL entry: %w", err)
+	}
+	i.walSeq++
+	return nil
+}
+
+// apply is the shared Add/resume path: it rebuilds a single Node from item,
+// merges it with its children off the stack if it is an inner node, writes
+// it to the pending batch, and pushes it back onto the stack. It does not
+// touch the WAL; callers that need WAL persistence (Add) or are replaying it
+// (resume) handle that separately.
+func (i *SnapshotImporter) apply(item SnapshotItem) error {
+	node := &Node{
+		key:     item.Key,
+		value:   item.Value,
+		version: item.Version,
+		height:  item.Height,
+	}
+
+	switch {
+	case node.height == 0:
+		if i.lastKey != nil && bytes.Compare(node.key, i.lastKey) <= 0 {
+			return ErrImportOutOfOrder
+		}
+		i.lastKey = node.key
+		node.size = 1
+
+	case len(i.stack) >= 2 &&
+		i.stack[len(i.stack)-1].height < node.height &&
+		i.stack[len(i.stack)-2].height < node.height:
+		left := i.stack[len(i.stack)-2]
+		right := i.stack[len(i.stack)-1]
+		node.leftNode, node.rightNode = left, right
+		node.leftHash, node.rightHash = left.hash, right.hash
+		node.size = left.size + right.size
+		i.stack = i.stack[:len(i.stack)-2]
+
+	default:
+		return fmt.Errorf("import: inner node at height %d arrived without two child subtrees on the stack", node.height)
+	}
+
+	node.hash = node._hash()
+
+	bz, err := node.encodedBytes()
+	if err != nil {
+		return fmt.Errorf("import: encoding node: %w", err)
+	}
+	if err := i.batch.Set(i.ndb.nodeKey(node.hash), bz); err != nil {
+		return fmt.Errorf("import: staging node: %w", err)
+	}
+
+	i.stack = append(i.stack, node)
+	return nil
+}
+
+// Commit finalizes the import: it checks the rebuilt root against the
+// expected hash, writes the accumulated node batch and root pointer into
+// the target tree's nodeDB atomically, registers the version as a flushed
+// snapshot (so PruningOptions treats it exactly like any other
+// FlushVersion'd version), and clears the WAL.
+func (i *SnapshotImporter) Commit() error {
+	var rootHash []byte
+	switch len(i.stack) {
+	case 0:
+		rootHash = nil
+	case 1:
+		rootHash = i.stack[0].hash
+	default:
+		return fmt.Errorf("import: incomplete tree, %d unmerged subtrees remain on the stack", len(i.stack))
+	}
+
+	if !bytes.Equal(rootHash, i.expectedHash) {
+		return fmt.Errorf("import: root hash %x does not match expected hash %x", rootHash, i.expectedHash)
+	}
+
+	if err := i.batch.Set(i.ndb.rootKey(i.version), rootHash); err != nil {
+		return fmt.Errorf("import: staging root pointer: %w", err)
+	}
+	if err := i.batch.WriteSync(); err != nil {
+		return fmt.Errorf("import: writing batch: %w", err)
+	}
+
+	if rootHash != nil {
+		if err := i.ndb.SaveSnapshot(rootHash); err != nil {
+			return fmt.Errorf("import: marking version %d as flushed: %w", i.version, err)
+		}
+	}
+	i.tree.versions[i.version] = true
+
+	if err := i.clearWAL(); err != nil {
+		return fmt.Errorf("import: clearing WAL: %w", err)
+	}
+	return nil
+}
+
+func (i *SnapshotImporter) clearWAL() error {
+	batch := i.wal.NewBatch()
+	defer batch.Close()
+
+	prefix := i.walPrefix()
+	it, err := i.wal.Iterator(prefix, prefixRangeEnd(prefix))
+	if err != nil {
+		return err
+	}
+	for ; it.Valid(); it.Next() {
+		if err := batch.Delete(it.Key()); err != nil {
+			it.Close()
+			return err
+		}
+	}
+	if err := it.Error(); err != nil {
+		it.Close()
+		return err
+	}
+	it.Close()
+
+	return batch.WriteSync()
+}
@@ -0,0 +1,197 @@
+package iavl
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	db "github.com/tendermint/tm-db"
+)
+
+// exportToImporter drains a SnapshotExporter through a channel, simulating a
+// state-sync provider that streams chunks to a remote peer, and feeds every
+// item into the given SnapshotImporter.
+func exportToImporter(t *testing.T, exporter *SnapshotExporter, importer *SnapshotImporter) {
+	t.Helper()
+
+	chunks := make(chan []SnapshotItem)
+	done := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		for {
+			items, err := exporter.Next()
+			if err == ErrorExportDone {
+				done <- nil
+				return
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+			chunks <- items
+		}
+	}()
+
+	for items := range chunks {
+		for _, item := range items {
+			require.NoError(t, importer.Add(item))
+		}
+	}
+	require.NoError(t, <-done)
+}
+
+func TestExportImport_Roundtrip(t *testing.T) {
+	const keyCount = 5000
+
+	memDB := db.NewMemDB()
+	tree, err := NewMutableTreeWithOpts(memDB, db.NewMemDB(), 0, PruningOptions(1, 0))
+	require.NoError(t, err)
+
+	for i := 0; i < keyCount; i++ {
+		tree.Set([]byte(fmt.Sprintf("key-%06d", i)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	rootHash, version, err := tree.SaveVersion()
+	require.NoError(t, err)
+	require.NoError(t, tree.FlushVersion(version))
+
+	exporter, err := tree.ExportSnapshot(version)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	newTree, err := NewMutableTreeWithOpts(db.NewMemDB(), db.NewMemDB(), 0, PruningOptions(1, 0))
+	require.NoError(t, err)
+
+	importer, err := newTree.ImportSnapshot(version, rootHash)
+	require.NoError(t, err)
+
+	exportToImporter(t, exporter, importer)
+	require.NoError(t, importer.Commit())
+
+	loaded, err := newTree.LoadVersion(version)
+	require.NoError(t, err)
+	require.Equal(t, version, loaded)
+
+	for i := 0; i < keyCount; i++ {
+		key := []byte(fmt.Sprintf("key-%06d", i))
+		_, want := tree.Get(key)
+		_, got := newTree.Get(key)
+		require.Equal(t, want, got, "mismatch for %s", key)
+	}
+
+	ok, err := newTree.ndb.HasSnapshot(rootHash)
+	require.NoError(t, err)
+	require.True(t, ok, "imported version should be treated as a flushed snapshot")
+}
+
+// TestExportImport_PreservesShapeAcrossVersions builds a tree the way a
+// real chain would - keys touched in arbitrary order across many versions,
+// some overwritten, none inserted in sorted order - to confirm the rebuilt
+// root hash matches even though it can't have come from replaying a single
+// ascending sequence of Sets.
+func TestExportImport_PreservesShapeAcrossVersions(t *testing.T) {
+	tree, err := NewMutableTreeWithOpts(db.NewMemDB(), db.NewMemDB(), 0, PruningOptions(1, 0))
+	require.NoError(t, err)
+
+	r := rand.New(rand.NewSource(2))
+	var rootHash []byte
+	var version int64
+	for v := 0; v < 20; v++ {
+		for i := 0; i < 50; i++ {
+			key := []byte(fmt.Sprintf("key-%d", r.Intn(200)))
+			value := []byte(fmt.Sprintf("v%d-%d", v, i))
+			tree.Set(key, value)
+		}
+		rootHash, version, err = tree.SaveVersion()
+		require.NoError(t, err)
+	}
+	require.NoError(t, tree.FlushVersion(version))
+
+	exporter, err := tree.ExportSnapshot(version)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	newTree, err := NewMutableTreeWithOpts(db.NewMemDB(), db.NewMemDB(), 0, PruningOptions(1, 0))
+	require.NoError(t, err)
+	importer, err := newTree.ImportSnapshot(version, rootHash)
+	require.NoError(t, err)
+
+	exportToImporter(t, exporter, importer)
+	require.NoError(t, importer.Commit())
+
+	loaded, err := newTree.LoadVersion(version)
+	require.NoError(t, err)
+	require.Equal(t, version, loaded)
+}
+
+func TestImport_OutOfOrder(t *testing.T) {
+	tree, err := NewMutableTreeWithOpts(db.NewMemDB(), db.NewMemDB(), 0, PruningOptions(1, 0))
+	require.NoError(t, err)
+
+	importer, err := tree.ImportSnapshot(1, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, importer.Add(SnapshotItem{Key: []byte("b"), Value: []byte{1}}))
+	require.Equal(t, ErrImportOutOfOrder, importer.Add(SnapshotItem{Key: []byte("a"), Value: []byte{2}}))
+	require.Equal(t, ErrImportOutOfOrder, importer.Add(SnapshotItem{Key: []byte("b"), Value: []byte{3}}))
+}
+
+// drainExporter collects every item a SnapshotExporter produces, in order.
+func drainExporter(t *testing.T, exporter *SnapshotExporter) []SnapshotItem {
+	t.Helper()
+	var all []SnapshotItem
+	for {
+		items, err := exporter.Next()
+		if err == ErrorExportDone {
+			return all
+		}
+		require.NoError(t, err)
+		all = append(all, items...)
+	}
+}
+
+func TestImport_ResumesAfterRestart(t *testing.T) {
+	source, err := NewMutableTreeWithOpts(db.NewMemDB(), db.NewMemDB(), 0, PruningOptions(1, 0))
+	require.NoError(t, err)
+	source.Set([]byte("a"), []byte{1})
+	source.Set([]byte("b"), []byte{2})
+	source.Set([]byte("c"), []byte{3})
+	rootHash, version, err := source.SaveVersion()
+	require.NoError(t, err)
+	require.NoError(t, source.FlushVersion(version))
+
+	exporter, err := source.ExportSnapshot(version)
+	require.NoError(t, err)
+	defer exporter.Close()
+
+	items := drainExporter(t, exporter)
+	require.True(t, len(items) > 1, "a 3-key tree should export at least one inner node plus its leaves")
+
+	tree, err := NewMutableTreeWithOpts(db.NewMemDB(), db.NewMemDB(), 0, PruningOptions(1, 0))
+	require.NoError(t, err)
+
+	importer, err := tree.ImportSnapshot(version, rootHash)
+	require.NoError(t, err)
+	require.NoError(t, importer.Add(items[0]))
+
+	// Simulate the process restarting mid-import: a fresh SnapshotImporter for the
+	// same (version, hash) replays what was already applied from the WAL,
+	// so the caller only needs to resume sending the remaining items.
+	resumed, err := tree.ImportSnapshot(version, rootHash)
+	require.NoError(t, err)
+	for _, item := range items[1:] {
+		require.NoError(t, resumed.Add(item))
+	}
+	require.NoError(t, resumed.Commit())
+
+	loaded, err := tree.LoadVersion(version)
+	require.NoError(t, err)
+	require.Equal(t, version, loaded)
+
+	for _, key := range [][]byte{[]byte("a"), []byte("b"), []byte("c")} {
+		_, want := source.Get(key)
+		_, got := tree.Get(key)
+		require.Equal(t, want, got)
+	}
+}
@@ -0,0 +1,362 @@
+package iavl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/btree"
+	db "github.com/tendermint/tm-db"
+)
+
+var (
+	errKeyEmpty = errors.New("key cannot be empty")
+	errValueNil = errors.New("value cannot be nil")
+)
+
+// memVersionedBTreeDegree matches the degree tm-db's own MemDB uses for its
+// backing btree, so MemVersionedNodeDB has comparable per-node overhead.
+const memVersionedBTreeDegree = 32
+
+// rootKeyPrefix is the first byte of the root-pointer key nodeDB writes once
+// per saved version (see nodeDB.rootKey). MemVersionedNodeDB watches for
+// writes of this key to know when a version boundary has been crossed, so
+// it can snapshot its btree without requiring any change to the existing
+// SaveVersion/FlushVersion code path.
+const rootKeyPrefix = byte('r')
+
+func versionFromRootKey(key []byte) (int64, bool) {
+	if len(key) != 9 || key[0] != rootKeyPrefix {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(key[1:])), true
+}
+
+// memDBItem adapts a key/value pair to btree.Item by comparing raw key
+// bytes, matching the ordering nodeDB relies on for range scans.
+type memDBItem struct {
+	key   []byte
+	value []byte
+}
+
+func (i *memDBItem) Less(than btree.Item) bool {
+	return bytes.Compare(i.key, than.(*memDBItem).key) < 0
+}
+
+// MemVersionedNodeDB is a copy-on-write, in-memory db.DB intended to be
+// passed as the recentDB argument of NewMutableTreeWithOpts in place of a
+// plain db.NewMemDB(). A plain MemDB-backed recentDB pays for every key
+// touched by every retained version; MemVersionedNodeDB instead keeps one
+// btree per retained version and leans on btree.Clone's structural sharing,
+// so SaveVersion is O(1) and the incremental memory cost of keeping another
+// version around is proportional to how much it actually changed, not to
+// the size of the tree.
+type MemVersionedNodeDB struct {
+	mtx      sync.RWMutex
+	current  *btree.BTree
+	versions map[int64]*btree.BTree
+
+	// isPinned, when set, reports whether a version is held open by an
+	// outstanding VersionSet view or SnapshotExporter (see versionset.go's
+	// pin registry). Delete consults it before evicting a version's
+	// retained snapshot, so a pinned version stays readable via IteratorAt
+	// even after its root key ages out of the current view. It is nil
+	// until SetPinChecker is called, which NewMutableTreeWithStrategy does
+	// automatically; callers that build a tree via the lower-level
+	// NewMutableTreeWithOpts directly need to call it themselves to get
+	// the same protection.
+	isPinned func(version int64) bool
+}
+
+// NewMemVersionedNodeDB creates an empty MemVersionedNodeDB.
+func NewMemVersionedNodeDB() *MemVersionedNodeDB {
+	return &MemVersionedNodeDB{
+		current:  btree.New(memVersionedBTreeDegree),
+		versions: make(map[int64]*btree.BTree),
+	}
+}
+
+// Get implements db.DB.
+func (m *MemVersionedNodeDB) Get(key []byte) ([]byte, error) {
+	if key == nil {
+		return nil, errKeyEmpty
+	}
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	item := m.current.Get(&memDBItem{key: key})
+	if item == nil {
+		return nil, nil
+	}
+	return item.(*memDBItem).value, nil
+}
+
+// Has implements db.DB.
+func (m *MemVersionedNodeDB) Has(key []byte) (bool, error) {
+	value, err := m.Get(key)
+	if err != nil {
+		return false, err
+	}
+	return value != nil, nil
+}
+
+// Set implements db.DB. Writing the root-pointer key for a version clones
+// the current btree into the versions map in O(1), capturing that version's
+// view before any further writes (which belong to the next version) land.
+func (m *MemVersionedNodeDB) Set(key, value []byte) error {
+	if key == nil {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.current.ReplaceOrInsert(&memDBItem{key: key, value: value})
+	if version, ok := versionFromRootKey(key); ok {
+		m.versions[version] = m.current.Clone()
+	}
+	return nil
+}
+
+// SetSync implements db.DB. There is nothing to sync for an in-memory store.
+func (m *MemVersionedNodeDB) SetSync(key, value []byte) error { return m.Set(key, value) }
+
+// Delete implements db.DB. Deleting a version's root-pointer key - exactly
+// what the existing pruning/expiry code path does to a version that has
+// aged out of the keep-recent window - also drops that version's retained
+// btree snapshot, mirroring the interception Set does on the way in. Without
+// this, m.versions would grow by one snapshot per SaveVersion forever,
+// regardless of how aggressively the caller prunes.
+//
+// If isPinned is set and reports the version as pinned, the snapshot is kept
+// even though the root key itself is still removed from the current view:
+// an outstanding VersionSet view or SnapshotExporter may still be reading it
+// through IteratorAt.
+func (m *MemVersionedNodeDB) Delete(key []byte) error {
+	if key == nil {
+		return errKeyEmpty
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.current.Delete(&memDBItem{key: key})
+	if version, ok := versionFromRootKey(key); ok {
+		if m.isPinned == nil || !m.isPinned(version) {
+			delete(m.versions, version)
+		}
+	}
+	return nil
+}
+
+// SetPinChecker wires m's retained-snapshot eviction to the same pin
+// registry a *nodeDB's VersionSet views and SnapshotExporters use, so that
+// the keep-recent aging path (the root-key deletes Delete intercepts above)
+// skips evicting a pinned version's snapshot the same way
+// pruneOrphanedVersions skips deleting its on-disk nodes. A snapshot kept
+// this way is not evicted later when the pin is released. It has no effect
+// on MutableTree.DeleteVersion itself, whose implementation does not
+// consult the pin registry at all.
+func (m *MemVersionedNodeDB) SetPinChecker(isPinned func(version int64) bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.isPinned = isPinned
+}
+
+// DeleteSync implements db.DB.
+func (m *MemVersionedNodeDB) DeleteSync(key []byte) error { return m.Delete(key) }
+
+// Iterator implements db.DB over the current (latest) view.
+func (m *MemVersionedNodeDB) Iterator(start, end []byte) (db.Iterator, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return newMemVersionedIterator(m.current, start, end, false), nil
+}
+
+// ReverseIterator implements db.DB over the current (latest) view.
+func (m *MemVersionedNodeDB) ReverseIterator(start, end []byte) (db.Iterator, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return newMemVersionedIterator(m.current, start, end, true), nil
+}
+
+// IteratorAt returns an iterator over the view saved for version, letting
+// callers range-scan a retained version without promoting it back to
+// current.
+func (m *MemVersionedNodeDB) IteratorAt(version int64, start, end []byte) (db.Iterator, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	tree, ok := m.versions[version]
+	if !ok {
+		return nil, fmt.Errorf("version %d is not retained in this recentDB", version)
+	}
+	return newMemVersionedIterator(tree, start, end, false), nil
+}
+
+// HasVersion reports whether version has a retained btree snapshot.
+func (m *MemVersionedNodeDB) HasVersion(version int64) bool {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	_, ok := m.versions[version]
+	return ok
+}
+
+// DeleteVersion drops version's snapshot. Any nodes that are not also
+// referenced by another retained version or the current view become
+// unreachable and are collected by the Go garbage collector; there is no
+// explicit free list to maintain.
+func (m *MemVersionedNodeDB) DeleteVersion(version int64) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.versions, version)
+}
+
+// ApproximateSize is a memory-accounting hook: a rough count of the
+// key/value nodes reachable across every retained version plus the current
+// view. Because versions share structure via copy-on-write clones, this
+// overcounts shared nodes - it is meant for relative comparisons (e.g. in
+// benchmarks) rather than an exact byte budget.
+func (m *MemVersionedNodeDB) ApproximateSize() int {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	total := m.current.Len()
+	for _, t := range m.versions {
+		total += t.Len()
+	}
+	return total
+}
+
+// Close implements db.DB.
+func (m *MemVersionedNodeDB) Close() error { return nil }
+
+// Print implements db.DB.
+func (m *MemVersionedNodeDB) Print() error {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	m.current.Ascend(func(i btree.Item) bool {
+		item := i.(*memDBItem)
+		fmt.Printf("[%X]:\t[%X]\n", item.key, item.value)
+		return true
+	})
+	return nil
+}
+
+// Stats implements db.DB.
+func (m *MemVersionedNodeDB) Stats() map[string]string {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return map[string]string{
+		"type":           "MemVersionedNodeDB",
+		"current.size":   fmt.Sprintf("%d", m.current.Len()),
+		"versions.count": fmt.Sprintf("%d", len(m.versions)),
+	}
+}
+
+// NewBatch implements db.DB.
+func (m *MemVersionedNodeDB) NewBatch() db.Batch {
+	return &memVersionedBatch{db: m}
+}
+
+type memVersionedOp struct {
+	delete bool
+	key    []byte
+	value  []byte
+}
+
+// memVersionedBatch implements db.Batch by buffering ops and applying them
+// one at a time through MemVersionedNodeDB.Set/Delete on Write, so the same
+// root-key detection that drives per-version snapshots applies to batched
+// writes too.
+type memVersionedBatch struct {
+	db  *MemVersionedNodeDB
+	ops []memVersionedOp
+}
+
+func (b *memVersionedBatch) Set(key, value []byte) error {
+	b.ops = append(b.ops, memVersionedOp{key: key, value: value})
+	return nil
+}
+
+func (b *memVersionedBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, memVersionedOp{delete: true, key: key})
+	return nil
+}
+
+func (b *memVersionedBatch) Write() error {
+	for _, op := range b.ops {
+		if op.delete {
+			if err := b.db.Delete(op.key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := b.db.Set(op.key, op.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *memVersionedBatch) WriteSync() error { return b.Write() }
+
+func (b *memVersionedBatch) Close() error {
+	b.ops = nil
+	return nil
+}
+
+// memVersionedIterator is a simple, materialize-then-walk db.Iterator over
+// a btree snapshot. Snapshots are only mutated by future clones (never
+// in-place once cloned), so it is always safe to walk.
+type memVersionedIterator struct {
+	items []*memDBItem
+	pos   int
+}
+
+func newMemVersionedIterator(tree *btree.BTree, start, end []byte, reverse bool) *memVersionedIterator {
+	items := make([]*memDBItem, 0, tree.Len())
+	visit := func(i btree.Item) bool {
+		item := i.(*memDBItem)
+		if start != nil && bytes.Compare(item.key, start) < 0 {
+			return true
+		}
+		if end != nil && bytes.Compare(item.key, end) >= 0 {
+			return true
+		}
+		items = append(items, item)
+		return true
+	}
+	tree.Ascend(visit)
+
+	if reverse {
+		for l, r := 0, len(items)-1; l < r; l, r = l+1, r-1 {
+			items[l], items[r] = items[r], items[l]
+		}
+	}
+	return &memVersionedIterator{items: items}
+}
+
+func (it *memVersionedIterator) Domain() (start, end []byte) { return nil, nil }
+func (it *memVersionedIterator) Valid() bool                 { return it.pos < len(it.items) }
+func (it *memVersionedIterator) Next()                       { it.pos++ }
+func (it *memVersionedIterator) Key() []byte                 { return it.items[it.pos].key }
+func (it *memVersionedIterator) Value() []byte               { return it.items[it.pos].value }
+func (it *memVersionedIterator) Error() error                { return nil }
+func (it *memVersionedIterator) Close() error                { it.items = nil; return nil }
+
+// HasRecent reports whether version is available in ndb's recentDB,
+// transparently taking the O(1) MemVersionedNodeDB.HasVersion path when
+// recentDB is one, and falling back to a root-key lookup for a plain
+// db.DB-backed recentDB otherwise.
+func (ndb *nodeDB) HasRecent(version int64) (bool, error) {
+	if ndb.recentDB == nil {
+		return false, nil
+	}
+	if mv, ok := ndb.recentDB.(*MemVersionedNodeDB); ok {
+		return mv.HasVersion(version), nil
+	}
+	value, err := ndb.recentDB.Get(ndb.rootKey(version))
+	if err != nil {
+		return false, err
+	}
+	return value != nil, nil
+}
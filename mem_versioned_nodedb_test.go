@@ -0,0 +1,189 @@
+package iavl
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	db "github.com/tendermint/tm-db"
+)
+
+func TestMemVersionedNodeDB_FlushVersion(t *testing.T) {
+	memDB := db.NewMemDB()
+	opts := PruningOptions(5, 1)
+
+	tree, err := NewMutableTreeWithOpts(memDB, NewMemVersionedNodeDB(), 0, opts)
+	require.NoError(t, err)
+	require.NotNil(t, tree)
+
+	rootHashes := make([][]byte, 0)
+	for i := int64(0); i < opts.KeepEvery; i++ {
+		tree.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i)))
+		rh, _, err := tree.SaveVersion() // nolint: govet
+		require.NoError(t, err)
+		rootHashes = append(rootHashes, rh)
+	}
+
+	for i, rh := range rootHashes {
+		version := int64(i + 1)
+		ok, err := tree.ndb.HasSnapshot(rh) // nolint: govet
+		require.NoError(t, err)
+		if version == 1 || version%opts.KeepEvery == 0 {
+			require.True(t, ok)
+		} else {
+			require.False(t, ok)
+		}
+	}
+
+	tree2, err := NewMutableTreeWithOpts(memDB, NewMemVersionedNodeDB(), 0, opts)
+	require.NoError(t, err)
+
+	v, err := tree2.LoadVersion(tree.Version())
+	require.NoError(t, err)
+	require.Equal(t, tree.Version(), v)
+
+	for i := int64(0); i < v; i++ {
+		_, value := tree2.Get([]byte(fmt.Sprintf("key-%d", i)))
+		require.Equal(t, []byte(fmt.Sprintf("value-%d", i)), value)
+	}
+}
+
+func TestMemVersionedNodeDB_HasRecent(t *testing.T) {
+	recent := NewMemVersionedNodeDB()
+	tree, err := NewMutableTreeWithOpts(db.NewMemDB(), recent, 0, PruningOptions(1000, 3))
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		tree.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i)))
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	for v := int64(1); v <= tree.Version(); v++ {
+		has, err := tree.ndb.HasRecent(v)
+		require.NoError(t, err)
+		require.Equal(t, v > tree.Version()-3, has, "version %d", v)
+	}
+}
+
+func TestMemVersionedNodeDB_DeleteVersionReleasesSnapshot(t *testing.T) {
+	recent := NewMemVersionedNodeDB()
+	recent.Set([]byte("r\x00\x00\x00\x00\x00\x00\x00\x01"), []byte{0xAA})
+	require.True(t, recent.HasVersion(1))
+
+	recent.DeleteVersion(1)
+	require.False(t, recent.HasVersion(1))
+}
+
+// TestMemVersionedNodeDB_DeleteRootKeyReleasesSnapshot exercises the same
+// root-key interception Delete performs as a side effect of whatever caller
+// expires an old version - the existing keep-recent pruning path deletes a
+// version's keys (root pointer included) via plain db.DB.Delete calls, never
+// DeleteVersion directly, so Delete itself has to be the one that evicts the
+// retained snapshot or m.versions grows forever.
+func TestMemVersionedNodeDB_DeleteRootKeyReleasesSnapshot(t *testing.T) {
+	recent := NewMemVersionedNodeDB()
+	rootKey := []byte("r\x00\x00\x00\x00\x00\x00\x00\x01")
+	require.NoError(t, recent.Set(rootKey, []byte{0xAA}))
+	require.True(t, recent.HasVersion(1))
+
+	require.NoError(t, recent.Delete(rootKey))
+	require.False(t, recent.HasVersion(1))
+}
+
+// TestMemVersionedNodeDB_SetPinChecker exercises Delete's pin check in
+// isolation, without going through a MutableTree.
+func TestMemVersionedNodeDB_SetPinChecker(t *testing.T) {
+	recent := NewMemVersionedNodeDB()
+	rootKey := []byte("r\x00\x00\x00\x00\x00\x00\x00\x01")
+	require.NoError(t, recent.Set(rootKey, []byte{0xAA}))
+	require.True(t, recent.HasVersion(1))
+
+	pinned := true
+	recent.SetPinChecker(func(version int64) bool { return pinned && version == 1 })
+
+	require.NoError(t, recent.Delete(rootKey))
+	require.True(t, recent.HasVersion(1), "pinned version's snapshot must survive Delete")
+
+	pinned = false
+	require.NoError(t, recent.Set(rootKey, []byte{0xAA}))
+	require.NoError(t, recent.Delete(rootKey))
+	require.False(t, recent.HasVersion(1), "unpinned version's snapshot must still be evicted")
+}
+
+// TestMemVersionedNodeDB_PinCheckerProtectsAgingSnapshot exercises the
+// wiring NewMutableTreeWithStrategy does between a *MemVersionedNodeDB
+// recentDB and the tree's pin registry: a version pinned by an outstanding
+// VersionSet view must keep its retained snapshot even after the
+// keep-recent window ages its root key out of the current view.
+func TestMemVersionedNodeDB_PinCheckerProtectsAgingSnapshot(t *testing.T) {
+	recent := NewMemVersionedNodeDB()
+	tree, err := NewMutableTreeWithStrategy(db.NewMemDB(), recent, 0, PruneSyncable)
+	require.NoError(t, err)
+	tree.opts = PruningOptions(1000, 1)
+
+	tree.Set([]byte("key-0"), []byte("value-0"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	require.True(t, recent.HasVersion(1))
+
+	// Pin version 1 before any later save ages it out of the one-version
+	// recent window.
+	vs := tree.Versions()
+	view, err := vs.View(1)
+	require.NoError(t, err)
+	require.True(t, tree.ndb.isPinned(1))
+
+	for i := 1; i < 4; i++ {
+		tree.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i)))
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	require.True(t, recent.HasVersion(1), "pinned version's recentDB snapshot must survive keep-recent aging")
+
+	require.NoError(t, view.Close())
+}
+
+func newChurnedKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%08d", i))
+	}
+	return keys
+}
+
+func benchmarkRecentBackend(b *testing.B, recentDB db.DB) {
+	b.Helper()
+	r := rand.New(rand.NewSource(1))
+
+	tree, err := NewMutableTreeWithOpts(db.NewMemDB(), recentDB, 0, PruningOptions(1000000, 100))
+	require.NoError(b, err)
+
+	keys := newChurnedKeys(10000)
+	for _, k := range keys {
+		tree.Set(k, []byte("value"))
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(b, err)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < len(keys)/100; j++ {
+			k := keys[r.Intn(len(keys))]
+			tree.Set(k, []byte(fmt.Sprintf("value-%d-%d", i, j)))
+		}
+		_, _, err := tree.SaveVersion()
+		require.NoError(b, err)
+	}
+}
+
+func BenchmarkRecentBackend_MemDB(b *testing.B) {
+	benchmarkRecentBackend(b, db.NewMemDB())
+}
+
+func BenchmarkRecentBackend_MemVersionedNodeDB(b *testing.B) {
+	benchmarkRecentBackend(b, NewMemVersionedNodeDB())
+}
@@ -0,0 +1,60 @@
+package iavl
+
+// Options configures how a MutableTree retains historical versions on disk
+// and whether writes to it are synced.
+type Options struct {
+	// Sync determines whether the nodeDB fsyncs on writes (see its flush
+	// path). Default: true.
+	Sync bool
+
+	// KeepEvery and KeepRecent control version retention: every KeepEvery'th
+	// version (and the first) is written through to the main db as a
+	// permanent snapshot; the KeepRecent most recent versions are also kept
+	// around (in the recentDB, when one is supplied) for fast access, and
+	// are dropped once they fall out of that window.
+	KeepEvery  int64
+	KeepRecent int64
+
+	// Strategy records which named PruningStrategy, if any, produced this
+	// Options value. It lets SetPruningStrategy recognize strategies whose
+	// behavior can't be captured by KeepEvery/KeepRecent alone (PruneNothing
+	// retains the same numeric shape as PruneEverything but must never
+	// schedule a deletion). Options built directly via PruningOptions leave
+	// this empty, which behaves like a custom strategy.
+	Strategy PruningStrategy
+}
+
+// DefaultOptions returns the Options a MutableTree uses when none are given
+// explicitly: fsync every write, and keep every version as a permanent
+// snapshot with no separate recent window.
+func DefaultOptions() *Options {
+	return &Options{Sync: true, KeepEvery: 1, KeepRecent: 0}
+}
+
+// BenchingOptions returns Options tuned for benchmarking: fsync is disabled,
+// since benchmarks measure the cost of tree operations, not disk durability.
+func BenchingOptions() *Options {
+	return &Options{Sync: false, KeepEvery: 1, KeepRecent: 0}
+}
+
+// PruningOptions returns an Options value with the given KeepEvery and
+// KeepRecent, fsync enabled, for callers that want to hand-tune pruning
+// rather than use one of the named PruningStrategy presets.
+func PruningOptions(keepEvery, keepRecent int64) *Options {
+	return &Options{Sync: true, KeepEvery: keepEvery, KeepRecent: keepRecent}
+}
+
+// defaultOptions returns the Options of the PruneDefault strategy. It is
+// distinct from DefaultOptions, which is what a tree built without explicit
+// options uses.
+func defaultOptions() *Options {
+	return PruneDefault.Options()
+}
+
+// shouldPrune reports whether versions falling outside KeepEvery/KeepRecent
+// should actually be deleted. It is false only for PruneNothing, which
+// shares PruneEverything's KeepEvery/KeepRecent shape but must retain every
+// version that has ever been saved.
+func (o *Options) shouldPrune() bool {
+	return o.Strategy != PruneNothing
+}
@@ -0,0 +1,218 @@
+package iavl
+
+import (
+	"fmt"
+
+	db "github.com/tendermint/tm-db"
+)
+
+// PruningStrategy is a named, pre-tuned pruning configuration. It mirrors
+// the presets the Cosmos SDK exposes for its root multi-store, so that
+// chains can pick a sensible retention policy without reasoning about
+// KeepEvery/KeepRecent directly.
+type PruningStrategy string
+
+const (
+	// PruneEverything retains only the most recent version; every older
+	// version is eligible for deletion as soon as a new one is saved.
+	PruneEverything PruningStrategy = "everything"
+
+	// PruneNothing retains every version ever saved. It shares
+	// PruneEverything's KeepEvery/KeepRecent numbers (every version is
+	// individually a flushed snapshot) but is never pruned.
+	PruneNothing PruningStrategy = "nothing"
+
+	// PruneDefault keeps a snapshot every 100 versions and the most recent
+	// 10000 versions: a reasonable choice for a node that wants to serve
+	// recent historical queries without retaining everything forever.
+	PruneDefault PruningStrategy = "default"
+
+	// PruneSyncable keeps widely-spaced snapshot waypoints suitable for
+	// serving state-sync snapshots (see SnapshotExporter/SnapshotImporter), plus a small
+	// recent window for ordinary operation.
+	PruneSyncable PruningStrategy = "syncable"
+)
+
+// Options returns the KeepEvery/KeepRecent pair this strategy corresponds
+// to, tagged with the strategy itself so SetPruningStrategy can recognize
+// PruneNothing's "never prune" behavior later.
+func (s PruningStrategy) Options() *Options {
+	switch s {
+	case PruneEverything:
+		return &Options{Sync: true, KeepEvery: 1, KeepRecent: 0, Strategy: s}
+	case PruneNothing:
+		return &Options{Sync: true, KeepEvery: 1, KeepRecent: 0, Strategy: s}
+	case PruneDefault:
+		return &Options{Sync: true, KeepEvery: 100, KeepRecent: 10000, Strategy: s}
+	case PruneSyncable:
+		return PruneSyncableWaypoint(10000, 100)
+	default:
+		return PruneDefault.Options()
+	}
+}
+
+// PruneSyncableWaypoint returns PruneSyncable Options with a caller-chosen
+// waypoint spacing and recent window, for chains that want state-sync
+// snapshots on a cadence other than the default 10000-version waypoint.
+func PruneSyncableWaypoint(keepEvery, keepRecent int64) *Options {
+	return &Options{Sync: true, KeepEvery: keepEvery, KeepRecent: keepRecent, Strategy: PruneSyncable}
+}
+
+// PruningStrategyFromString parses one of the named strategy constants,
+// returning an error for anything else so typos in config files fail fast.
+func PruningStrategyFromString(s string) (PruningStrategy, error) {
+	switch strategy := PruningStrategy(s); strategy {
+	case PruneEverything, PruneNothing, PruneDefault, PruneSyncable:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown pruning strategy %q", s)
+	}
+}
+
+// NewMutableTreeWithStrategy creates a MutableTree configured with a named
+// PruningStrategy instead of hand-tuned Options.
+//
+// If recentDB is a *MemVersionedNodeDB, this also wires its pin checker to
+// the resulting tree's nodeDB (see MemVersionedNodeDB.SetPinChecker), so a
+// pinned version's retained snapshot survives the keep-recent aging path
+// the same way pruneOrphanedVersions already defers to it. Callers that
+// build a tree via NewMutableTreeWithOpts directly don't get this wiring
+// automatically and need to call SetPinChecker themselves.
+func NewMutableTreeWithStrategy(dbs db.DB, recentDB db.DB, cacheSize int, strategy PruningStrategy) (*MutableTree, error) {
+	tree, err := NewMutableTreeWithOpts(dbs, recentDB, cacheSize, strategy.Options())
+	if err != nil {
+		return nil, err
+	}
+	if mv, ok := recentDB.(*MemVersionedNodeDB); ok {
+		mv.SetPinChecker(tree.ndb.isPinned)
+	}
+	return tree, nil
+}
+
+// SetPruningStrategy safely transitions an already-loaded tree to a new
+// named pruning strategy. Tightening retention (keeping less) deletes
+// versions that fall outside the new window; loosening retention (keeping
+// more, including switching to PruneNothing) is refused if the additional
+// history it requires has already been pruned from disk.
+//
+// The deletion runs synchronously, on the calling goroutine, rather than in
+// the background: MutableTree's version bookkeeping (tree.versions) is a
+// plain map with no internal locking anywhere in this codebase, so deleting
+// versions from a separate goroutine while the caller goes on to Set/
+// SaveVersion concurrently would be a data race.
+func (tree *MutableTree) SetPruningStrategy(strategy PruningStrategy) error {
+	newOpts := strategy.Options()
+	oldOpts := tree.opts
+
+	switch {
+	case loosensRetention(oldOpts, newOpts):
+		if err := tree.ensureHistoryAvailable(newOpts); err != nil {
+			return fmt.Errorf("cannot switch to %q pruning strategy: %w", strategy, err)
+		}
+	case tightensRetention(oldOpts, newOpts):
+		tree.pruneOrphanedVersions(oldOpts, newOpts)
+	}
+
+	tree.opts = newOpts
+	return nil
+}
+
+// loosensRetention reports whether switching from old to new requires
+// keeping strictly more history than before.
+func loosensRetention(old, new *Options) bool {
+	if !new.shouldPrune() {
+		return old.shouldPrune()
+	}
+	return old.shouldPrune() && new.KeepRecent > old.KeepRecent
+}
+
+// tightensRetention reports whether switching from old to new allows
+// discarding history that old would have kept.
+func tightensRetention(old, new *Options) bool {
+	if !new.shouldPrune() {
+		return false
+	}
+	if !old.shouldPrune() {
+		// old (e.g. PruneNothing) kept every version ever saved; anything
+		// that actually prunes is strictly tighter than that.
+		return true
+	}
+	return new.KeepRecent < old.KeepRecent
+}
+
+// ensureHistoryAvailable verifies that every version the new Options would
+// keep in its recent window (for a strategy that never prunes, every
+// version back to 1) is still present on disk, so that loosening pruning
+// never silently serves a truncated history.
+func (tree *MutableTree) ensureHistoryAvailable(newOpts *Options) error {
+	latest := tree.Version()
+	oldest := int64(1)
+	if newOpts.shouldPrune() {
+		oldest = latest - newOpts.KeepRecent
+		if oldest < 1 {
+			oldest = 1
+		}
+	}
+
+	for v := oldest; v <= latest; v++ {
+		ok, err := tree.ndb.HasVersion(v)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("version %d is required by the new strategy but no longer exists on disk", v)
+		}
+	}
+	return nil
+}
+
+// keepsAsSnapshot reports whether new would still keep version as a
+// permanent snapshot, and so pruneOrphanedVersions must not delete it.
+// PruneEverything's contract is "retain only the latest version" even
+// though it shares PruneNothing's KeepEvery=1: treating KeepEvery=1 as a
+// waypoint-every-version rule here (as the general formula below does for
+// PruneDefault/PruneSyncable) would make tightening to PruneEverything
+// delete nothing at all.
+func (new *Options) keepsAsSnapshot(version, latest int64) bool {
+	if new.Strategy == PruneEverything {
+		return version == latest
+	}
+	return version == 1 || (new.KeepEvery > 0 && version%new.KeepEvery == 0)
+}
+
+// pruneOrphanedVersions deletes versions that the old Options retained but
+// the new, tighter Options does not. It never deletes a version the new
+// Options would still keep as a flushed snapshot, and it skips any version
+// pinned by an outstanding VersionSet view or SnapshotExporter (see
+// versionset.go). A skipped version stays on disk: closing the view does
+// not delete it.
+func (tree *MutableTree) pruneOrphanedVersions(old, new *Options) {
+	latest := tree.Version()
+
+	// old.KeepRecent only bounds what old actually retained when old prunes
+	// at all. PruneNothing shares PruneEverything's KeepRecent=0 but keeps
+	// every version back to 1, so treating it like a normal KeepRecent=0
+	// window here would make start collapse to latest and skip everything.
+	start := int64(1)
+	if old.shouldPrune() {
+		start = latest - old.KeepRecent
+		if start < 1 {
+			start = 1
+		}
+	}
+	cutoff := latest - new.KeepRecent
+
+	for v := start; v < cutoff; v++ {
+		if new.keepsAsSnapshot(v, latest) {
+			continue
+		}
+		if tree.ndb.isPinned(v) {
+			continue
+		}
+		ok, err := tree.ndb.HasVersion(v)
+		if err != nil || !ok {
+			continue
+		}
+		_ = tree.DeleteVersion(v)
+	}
+}
@@ -0,0 +1,188 @@
+package iavl
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// pinRegistry reference-counts outstanding VersionSet views per nodeDB, so
+// that code which wants to defer deleting a version until every view of it
+// is closed has somewhere to check. It is keyed off the *nodeDB pointer in a
+// package-level side table rather than a field on nodeDB, since adding
+// pin-tracking shouldn't require touching every existing nodeDB call site or
+// constructor.
+//
+// Consumers: pruneOrphanedVersions (see pruning.go) skips a pinned version
+// when SetPruningStrategy tightens retention, and MemVersionedNodeDB.Delete
+// (see mem_versioned_nodedb.go), when wired up via SetPinChecker, keeps a
+// pinned version's retained snapshot alive through the ordinary keep-recent
+// aging path. A direct call to MutableTree.DeleteVersion does not consult
+// this registry at all - its implementation predates pinning - so a pin
+// still does not protect against that specific call path, only against the
+// two above.
+var treePins sync.Map // map[*nodeDB]*pinRegistry
+
+type pinRegistry struct {
+	mtx    sync.Mutex
+	counts map[int64]int
+}
+
+func pinsFor(ndb *nodeDB) *pinRegistry {
+	v, _ := treePins.LoadOrStore(ndb, &pinRegistry{counts: make(map[int64]int)})
+	return v.(*pinRegistry)
+}
+
+// pinVersion marks version as referenced by an outstanding VersionSet view.
+func (ndb *nodeDB) pinVersion(version int64) {
+	p := pinsFor(ndb)
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.counts[version]++
+}
+
+// unpinVersion releases one reference taken by pinVersion.
+func (ndb *nodeDB) unpinVersion(version int64) {
+	p := pinsFor(ndb)
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.counts[version] <= 1 {
+		delete(p.counts, version)
+		return
+	}
+	p.counts[version]--
+}
+
+// isPinned reports whether version is held open by at least one outstanding
+// VersionSet view. pruneOrphanedVersions consults this before removing a
+// version's nodes and skips a pinned version; releasing the last pin does
+// not delete it.
+func (ndb *nodeDB) isPinned(version int64) bool {
+	p := pinsFor(ndb)
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.counts[version] > 0
+}
+
+// VersionSet is an immutable snapshot of a MutableTree's saved version
+// history, taken at the time Versions() was called and unaffected by any
+// SaveVersion or DeleteVersion the parent tree undergoes afterwards.
+type VersionSet struct {
+	tree     *MutableTree
+	versions []int64 // ascending, fixed at capture time
+}
+
+// Versions returns a VersionSet capturing every version currently saved on
+// tree.
+func (tree *MutableTree) Versions() VersionSet {
+	versions := make([]int64, 0, len(tree.versions))
+	for v, saved := range tree.versions {
+		if saved {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return VersionSet{tree: tree, versions: versions}
+}
+
+// Count returns the number of versions in the set.
+func (vs VersionSet) Count() int { return len(vs.versions) }
+
+// Latest returns the most recent version in the set, or 0 if it is empty.
+func (vs VersionSet) Latest() int64 {
+	if len(vs.versions) == 0 {
+		return 0
+	}
+	return vs.versions[len(vs.versions)-1]
+}
+
+// Exists reports whether v was part of the history captured by this set.
+func (vs VersionSet) Exists(v int64) bool {
+	i := sort.Search(len(vs.versions), func(i int) bool { return vs.versions[i] >= v })
+	return i < len(vs.versions) && vs.versions[i] == v
+}
+
+// Equal reports whether two sets capture exactly the same versions.
+func (vs VersionSet) Equal(other VersionSet) bool {
+	if len(vs.versions) != len(other.versions) {
+		return false
+	}
+	for i, v := range vs.versions {
+		if other.versions[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// VersionIterator walks a VersionSet's versions in ascending order.
+type VersionIterator struct {
+	versions []int64
+	pos      int
+}
+
+// Valid reports whether the iterator is positioned at a version.
+func (it *VersionIterator) Valid() bool { return it.pos < len(it.versions) }
+
+// Next advances the iterator.
+func (it *VersionIterator) Next() { it.pos++ }
+
+// Value returns the version the iterator is currently positioned at.
+func (it *VersionIterator) Value() int64 { return it.versions[it.pos] }
+
+// Iterator returns an ascending iterator over the set's versions.
+func (vs VersionSet) Iterator() *VersionIterator {
+	return &VersionIterator{versions: vs.versions}
+}
+
+// viewPins records which nodeDB and version an ImmutableTree returned by
+// VersionSet.View has pinned, so that Close can release it. It is a
+// side table rather than a field on ImmutableTree for the same reason
+// pinRegistry is one: most ImmutableTrees are never views and shouldn't pay
+// for pin bookkeeping.
+var viewPins sync.Map // map[*ImmutableTree]pinnedView
+
+type pinnedView struct {
+	ndb     *nodeDB
+	version int64
+}
+
+// View returns a pinned, read-only tree at version v. While the returned
+// tree is open, SetPruningStrategy will not delete version v out from under
+// it even if the new strategy would otherwise prune it (see
+// pruneOrphanedVersions in pruning.go), and - if the tree was built with
+// NewMutableTreeWithStrategy over a *MemVersionedNodeDB recentDB - the
+// ordinary keep-recent aging path will not evict v's retained snapshot
+// either (see MemVersionedNodeDB.SetPinChecker). This does not protect
+// against a direct MutableTree.DeleteVersion(v) call, whose implementation
+// does not consult the pin registry. Release the pin by calling Close on
+// the returned tree.
+func (vs VersionSet) View(v int64) (*ImmutableTree, error) {
+	if !vs.Exists(v) {
+		return nil, fmt.Errorf("version %d is not part of this version set", v)
+	}
+
+	vs.tree.ndb.pinVersion(v)
+	itree, err := vs.tree.GetImmutable(v)
+	if err != nil {
+		vs.tree.ndb.unpinVersion(v)
+		return nil, fmt.Errorf("view version %d: %w", v, err)
+	}
+
+	viewPins.Store(itree, pinnedView{ndb: vs.tree.ndb, version: v})
+	return itree, nil
+}
+
+// Close releases a pinned view obtained from VersionSet.View, allowing the
+// version it pinned to be deleted or pruned again. It is a no-op, and safe
+// to call more than once, for an ImmutableTree not obtained from a
+// VersionSet.
+func (tree *ImmutableTree) Close() error {
+	v, ok := viewPins.LoadAndDelete(tree)
+	if !ok {
+		return nil
+	}
+	pin := v.(pinnedView)
+	pin.ndb.unpinVersion(pin.version)
+	return nil
+}
@@ -0,0 +1,93 @@
+package iavl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	db "github.com/tendermint/tm-db"
+)
+
+func TestVersionSet_Basics(t *testing.T) {
+	tree, err := NewMutableTreeWithOpts(db.NewMemDB(), db.NewMemDB(), 0, PruningOptions(1, 0))
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		tree.Set([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", i)))
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	vs := tree.Versions()
+	require.Equal(t, 5, vs.Count())
+	require.Equal(t, int64(5), vs.Latest())
+	require.True(t, vs.Exists(3))
+	require.False(t, vs.Exists(6))
+
+	var seen []int64
+	for it := vs.Iterator(); it.Valid(); it.Next() {
+		seen = append(seen, it.Value())
+	}
+	require.Equal(t, []int64{1, 2, 3, 4, 5}, seen)
+
+	tree.Set([]byte("key-5"), []byte("value-5"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	require.False(t, vs.Equal(tree.Versions()))
+	require.True(t, vs.Equal(vs))
+}
+
+// TestVersionSet_ViewPinLifecycle checks that View/Close correctly pin and
+// unpin a version. It deliberately does not call tree.DeleteVersion
+// directly: that method's implementation predates pinning and does not
+// consult isPinned, so it would not demonstrate anything about the pin
+// registry. The guarantee the pin registry actually provides - that
+// SetPruningStrategy skips deleting a pinned version - is covered by
+// TestPruneOrphanedVersions_SkipsPinnedAndKeepEvery in pruning_test.go.
+func TestVersionSet_ViewPinLifecycle(t *testing.T) {
+	tree, err := NewMutableTreeWithOpts(db.NewMemDB(), db.NewMemDB(), 0, PruningOptions(1, 0))
+	require.NoError(t, err)
+
+	tree.Set([]byte("k1"), []byte("v1"))
+	_, version1, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	tree.Set([]byte("k1"), []byte("v1-updated"))
+	_, version2, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	vs := tree.Versions()
+
+	view1, err := vs.View(version1)
+	require.NoError(t, err)
+	view2, err := vs.View(version2)
+	require.NoError(t, err)
+
+	require.True(t, tree.ndb.isPinned(version1))
+	require.True(t, tree.ndb.isPinned(version2))
+
+	_, value := view1.Get([]byte("k1"))
+	require.Equal(t, []byte("v1"), value)
+	_, value = view2.Get([]byte("k1"))
+	require.Equal(t, []byte("v1-updated"), value)
+
+	require.NoError(t, view1.Close())
+	require.False(t, tree.ndb.isPinned(version1))
+
+	require.NoError(t, view2.Close())
+	require.False(t, tree.ndb.isPinned(version2))
+}
+
+func TestVersionSet_ViewUnknownVersion(t *testing.T) {
+	tree, err := NewMutableTreeWithOpts(db.NewMemDB(), db.NewMemDB(), 0, PruningOptions(1, 0))
+	require.NoError(t, err)
+	tree.Set([]byte("k"), []byte("v"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	vs := tree.Versions()
+	_, err = vs.View(999)
+	require.Error(t, err)
+}